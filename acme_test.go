@@ -0,0 +1,32 @@
+package restserver
+
+import "testing"
+
+func TestNewAutocertManager(t *testing.T) {
+	hosts := []string{"repo.example.com"}
+	cacheDir := "/var/lib/rest-server/acme"
+
+	t.Run("production talks to the default ACME directory", func(t *testing.T) {
+		m := newAutocertManager(hosts, cacheDir, false)
+		if m.Client != nil {
+			t.Errorf("expected the default autocert.Manager ACME client, got a custom one pointed at %q", m.Client.DirectoryURL)
+		}
+	})
+
+	t.Run("staging talks to the Let's Encrypt staging directory", func(t *testing.T) {
+		m := newAutocertManager(hosts, cacheDir, true)
+		if m.Client == nil || m.Client.DirectoryURL != acmeStagingDirectoryURL {
+			t.Errorf("expected client.DirectoryURL %q, got %+v", acmeStagingDirectoryURL, m.Client)
+		}
+	})
+
+	t.Run("host policy restricts to the configured hosts", func(t *testing.T) {
+		m := newAutocertManager(hosts, cacheDir, false)
+		if err := m.HostPolicy(nil, "repo.example.com"); err != nil {
+			t.Errorf("expected configured host to be allowed: %v", err)
+		}
+		if err := m.HostPolicy(nil, "evil.example.com"); err == nil {
+			t.Error("expected an unconfigured host to be rejected")
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package restserver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/rest-server/metrics"
+)
+
+// PasswordCacheDuration represents how long authentication credentials are
+// cached in memory after they were successfully verified. This allows
+// avoiding repeatedly verifying the same authentication credentials against
+// potentially slow backends.
+const PasswordCacheDuration = time.Minute
+
+// Authenticator validates user credentials against a backend, e.g. an
+// htpasswd file, an LDAP directory or an external helper program.
+type Authenticator interface {
+	// Validate returns true if password is correct for user. A non-nil
+	// error indicates the backend itself could not be reached or queried,
+	// as opposed to the credentials simply being wrong.
+	Validate(user, password string) (bool, error)
+
+	// Name returns a short identifier for the authenticator, used in log
+	// messages and metrics.
+	Name() string
+}
+
+// cacheEntry holds a verifier for credentials that were successfully
+// validated, so that repeated requests with the same credentials don't hit
+// a potentially slow backend again.
+type cacheEntry struct {
+	expiry   time.Time
+	verifier []byte
+}
+
+// cachingAuthenticator wraps an Authenticator with an in-memory cache of
+// recently validated credentials, keyed by user, with a SHA-256 hash of
+// "user:password" stored as the verifier.
+type cachingAuthenticator struct {
+	inner    Authenticator
+	duration time.Duration
+
+	mutex sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// newCachingAuthenticator wraps inner so that successful validations are
+// remembered for duration; each cache hit extends the entry by another
+// duration, so credentials are only checked against inner again once they
+// have gone unused for that long.
+func newCachingAuthenticator(inner Authenticator, duration time.Duration) *cachingAuthenticator {
+	c := &cachingAuthenticator{
+		inner:    inner,
+		duration: duration,
+		cache:    make(map[string]cacheEntry),
+	}
+	go c.expiryTimer()
+	return c
+}
+
+func (c *cachingAuthenticator) Name() string { return c.inner.Name() }
+
+func (c *cachingAuthenticator) Validate(user, password string) (bool, error) {
+	hash := sha256.New()
+	// hash.Write can never fail
+	_, _ = hash.Write([]byte(user))
+	_, _ = hash.Write([]byte(":"))
+	_, _ = hash.Write([]byte(password))
+	verifier := hash.Sum(nil)
+
+	start := time.Now()
+
+	c.mutex.Lock()
+	entry, cached := c.cache[user]
+	c.mutex.Unlock()
+
+	if cached && subtle.ConstantTimeCompare(entry.verifier, verifier) == 1 {
+		metrics.AuthAttemptsTotal.WithLabelValues(metrics.ResultCacheHit).Inc()
+		metrics.AuthDurationSeconds.WithLabelValues("cache").Observe(time.Since(start).Seconds())
+
+		c.mutex.Lock()
+		c.cache[user] = cacheEntry{verifier: verifier, expiry: time.Now().Add(c.duration)}
+		c.mutex.Unlock()
+		return true, nil
+	}
+
+	ok, err := c.inner.Validate(user, password)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	c.mutex.Lock()
+	c.cache[user] = cacheEntry{verifier: verifier, expiry: time.Now().Add(c.duration)}
+	c.mutex.Unlock()
+
+	return true, nil
+}
+
+// NewAuthenticatorFromSpec builds an Authenticator from a --auth spec. An
+// empty spec selects the on-disk htpasswd file at htpasswdPath, reloaded as
+// configured by htpasswdReload (see HtpasswdOptions.CheckInterval).
+// Recognized non-empty specs are "ldap:<url>|<binddn template>", e.g.
+// "ldap:ldap://dc1.example.com|uid=%s,ou=people,dc=example,dc=com", and
+// "exec:<command> [args...]", where the username is appended to argv and
+// the password is written to the helper's stdin.
+func NewAuthenticatorFromSpec(spec, htpasswdPath string, htpasswdReload time.Duration) (Authenticator, error) {
+	if spec == "" {
+		return NewHtpasswdFromFileWithOptions(htpasswdPath, HtpasswdOptions{CheckInterval: htpasswdReload})
+	}
+
+	backend, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid auth spec %q, want <backend>:<options>", spec)
+	}
+
+	switch backend {
+	case "ldap":
+		url, bindDNFmt, ok := strings.Cut(rest, "|")
+		if !ok {
+			return nil, fmt.Errorf("invalid ldap auth spec %q, want ldap:<url>|<binddn template>", spec)
+		}
+		return NewLDAPAuthenticator(url, bindDNFmt), nil
+	case "exec":
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid exec auth spec %q, want exec:<command> [args...]", spec)
+		}
+		return NewExecAuthenticator(fields[0], fields[1:], 10*time.Second), nil
+	default:
+		return nil, fmt.Errorf("unknown auth backend %q", backend)
+	}
+}
+
+// expiryTimer periodically deletes expired cache entries. The stored
+// verifier is a SHA-256 digest, not a secret, so there's no need to scrub
+// it before dropping the entry.
+func (c *cachingAuthenticator) expiryTimer() {
+	for {
+		time.Sleep(5 * time.Second)
+		now := time.Now()
+		c.mutex.Lock()
+		for user, entry := range c.cache {
+			if now.After(entry.expiry) {
+				delete(c.cache, user)
+			}
+		}
+		c.mutex.Unlock()
+	}
+}
@@ -0,0 +1,64 @@
+package restserver
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/restic/rest-server/metrics"
+)
+
+// ExecAuthenticator authenticates users by running an external helper
+// program, with the username appended to argv and the password written to
+// stdin. Exit code 0 means the credentials are valid.
+type ExecAuthenticator struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+// NewExecAuthenticator returns an Authenticator that runs command (with
+// args, the username is appended as the final argument) for each
+// authentication attempt, bounded by timeout.
+func NewExecAuthenticator(command string, args []string, timeout time.Duration) *ExecAuthenticator {
+	return &ExecAuthenticator{command: command, args: args, timeout: timeout}
+}
+
+// Name implements Authenticator.
+func (a *ExecAuthenticator) Name() string { return "exec" }
+
+// Validate implements Authenticator.
+func (a *ExecAuthenticator) Validate(user, password string) (bool, error) {
+	start := time.Now()
+	result := metrics.ResultBadPassword
+	defer func() {
+		metrics.AuthAttemptsTotal.WithLabelValues(result).Inc()
+		metrics.AuthDurationSeconds.WithLabelValues("exec").Observe(time.Since(start).Seconds())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, a.command, append(a.args, user)...)
+	cmd.Stdin = strings.NewReader(password)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			// exec.CommandContext kills the process on deadline, which also
+			// surfaces as a *exec.ExitError ("signal: killed"); check this
+			// first so a hung helper returns a timeout error rather than being
+			// treated as rejected credentials (the attempt metric still records
+			// bad_password).
+			return false, fmt.Errorf("exec: %s timed out after %s", a.command, a.timeout)
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return false, nil
+		}
+		return false, fmt.Errorf("exec: running %s: %w", a.command, err)
+	}
+
+	result = metrics.ResultOK
+	return true, nil
+}
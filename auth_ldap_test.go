@@ -0,0 +1,31 @@
+package restserver
+
+import "testing"
+
+func TestLDAPAuthenticatorRejectsDNUnsafeUsernames(t *testing.T) {
+	// Use an LDAP URL that nothing listens on: a DN-unsafe username must be
+	// rejected before the authenticator ever tries to dial it.
+	a := NewLDAPAuthenticator("ldap://127.0.0.1:1", "uid=%s,ou=people,dc=example,dc=com")
+
+	var tests = []string{
+		"foo,ou=admins,dc=example,dc=com",
+		"foo+bar",
+		`foo"bar`,
+		"foo=bar",
+		"foo;bar",
+		"foo<bar>",
+		"foo\\bar",
+	}
+
+	for _, user := range tests {
+		t.Run(user, func(t *testing.T) {
+			ok, err := a.Validate(user, "password")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok {
+				t.Fatalf("expected validation to fail for DN-unsafe username %q", user)
+			}
+		})
+	}
+}
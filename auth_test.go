@@ -0,0 +1,187 @@
+package restserver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewAuthenticatorFromSpec(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "rest-server-auth-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	htpasswdPath := filepath.Join(tempdir, ".htpasswd")
+	if err := ioutil.WriteFile(htpasswdPath, []byte("user:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name    string
+		spec    string
+		path    string
+		wantErr bool
+		check   func(t *testing.T, a Authenticator)
+	}{
+		{
+			name: "empty spec selects htpasswd",
+			spec: "",
+			path: htpasswdPath,
+			check: func(t *testing.T, a Authenticator) {
+				if a.Name() != "htpasswd" {
+					t.Errorf("wrong Name(), got %q", a.Name())
+				}
+			},
+		},
+		{
+			name:    "empty spec with missing htpasswd file fails",
+			spec:    "",
+			path:    filepath.Join(tempdir, "does-not-exist"),
+			wantErr: true,
+		},
+		{
+			name: "valid ldap spec",
+			spec: "ldap:ldap://dc1.example.com|uid=%s,ou=people,dc=example,dc=com",
+			path: htpasswdPath,
+			check: func(t *testing.T, a Authenticator) {
+				if a.Name() != "ldap" {
+					t.Errorf("wrong Name(), got %q", a.Name())
+				}
+			},
+		},
+		{
+			name:    "ldap spec without binddn template fails",
+			spec:    "ldap:ldap://dc1.example.com",
+			path:    htpasswdPath,
+			wantErr: true,
+		},
+		{
+			name: "valid exec spec",
+			spec: "exec:/usr/bin/auth-helper --flag",
+			path: htpasswdPath,
+			check: func(t *testing.T, a Authenticator) {
+				if a.Name() != "exec" {
+					t.Errorf("wrong Name(), got %q", a.Name())
+				}
+			},
+		},
+		{
+			name:    "exec spec without a command fails",
+			spec:    "exec:",
+			path:    htpasswdPath,
+			wantErr: true,
+		},
+		{
+			name:    "unknown backend fails",
+			spec:    "bogus:whatever",
+			path:    htpasswdPath,
+			wantErr: true,
+		},
+		{
+			name:    "spec without colon fails",
+			spec:    "bogus",
+			path:    htpasswdPath,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := NewAuthenticatorFromSpec(test.spec, test.path, 0)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.check != nil {
+				test.check(t, a)
+			}
+		})
+	}
+}
+
+// fakeAuthenticator counts how many times Validate is called, so tests can
+// verify that cachingAuthenticator actually avoids hitting the backend.
+type fakeAuthenticator struct {
+	calls int
+	valid bool
+	err   error
+}
+
+func (f *fakeAuthenticator) Name() string { return "fake" }
+
+func (f *fakeAuthenticator) Validate(user, password string) (bool, error) {
+	f.calls++
+	return f.valid, f.err
+}
+
+func TestCachingAuthenticatorCachesSuccessfulValidations(t *testing.T) {
+	inner := &fakeAuthenticator{valid: true}
+	c := newCachingAuthenticator(inner, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		ok, err := c.Validate("user", "password")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok {
+			t.Fatalf("call %d: expected success", i)
+		}
+	}
+
+	if inner.calls != 1 {
+		t.Errorf("expected inner authenticator to be called once, got %d calls", inner.calls)
+	}
+}
+
+func TestCachingAuthenticatorMissesOnWrongPassword(t *testing.T) {
+	inner := &fakeAuthenticator{valid: false}
+	c := newCachingAuthenticator(inner, time.Minute)
+
+	ok, err := c.Validate("user", "wrong")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("expected failure for wrong password")
+	}
+
+	ok, err = c.Validate("user", "wrong")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Fatal("expected failure for wrong password")
+	}
+
+	if inner.calls != 2 {
+		t.Errorf("expected inner authenticator to be called twice (never cached), got %d calls", inner.calls)
+	}
+}
+
+func TestCachingAuthenticatorPropagatesBackendErrors(t *testing.T) {
+	wantErr := errors.New("backend unreachable")
+	inner := &fakeAuthenticator{valid: false, err: wantErr}
+	c := newCachingAuthenticator(inner, time.Minute)
+
+	_, err := c.Validate("user", "password")
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("wrong error, want %v, got %v", wantErr, err)
+	}
+}
+
+func TestCachingAuthenticatorName(t *testing.T) {
+	c := newCachingAuthenticator(&fakeAuthenticator{}, time.Minute)
+	if c.Name() != "fake" {
+		t.Errorf("wrong Name(), want %q, got %q", "fake", c.Name())
+	}
+}
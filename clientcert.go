@@ -0,0 +1,75 @@
+package restserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// CertUsernameField selects which field of a verified client certificate is
+// used as the restic username when --auth-cert is enabled.
+type CertUsernameField string
+
+// Supported values for CertUsernameField.
+const (
+	CertUsernameCN    CertUsernameField = "cn"
+	CertUsernameURI   CertUsernameField = "uri"
+	CertUsernameEmail CertUsernameField = "email"
+)
+
+// newClientCertTLSConfig returns a tls.Config that requires and verifies a
+// client certificate signed by one of the CAs in caFile against, for use
+// with --auth-cert --client-ca.
+func newClientCertTLSConfig(caFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read client CA file: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %v", caFile)
+	}
+
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}, nil
+}
+
+// checkCertAuth authenticates r using the TLS client certificate presented
+// on the connection. It requires the certificate to have been verified
+// against the configured client CA pool and extracts the username from the
+// field named by s.CertUsernameField (CN by default). The extracted value
+// becomes the per-user subdirectory owner under PrivateRepos, so it's
+// rejected unless it passes the same validUsernameRegexp check htpasswd
+// usernames are held to, rather than trusted as a filesystem-path component.
+func (s *Server) checkCertAuth(r *http.Request) (username string, ok bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+
+	switch s.CertUsernameField {
+	case CertUsernameURI:
+		if len(cert.URIs) == 0 {
+			return "", false
+		}
+		username = cert.URIs[0].String()
+	case CertUsernameEmail:
+		if len(cert.EmailAddresses) == 0 {
+			return "", false
+		}
+		username = cert.EmailAddresses[0]
+	default:
+		username = cert.Subject.CommonName
+	}
+
+	if !validUsernameRegexp.MatchString(username) {
+		return "", false
+	}
+	return username, true
+}
@@ -0,0 +1,112 @@
+package restserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func requestWithVerifiedCert(cert *x509.Certificate) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return req
+}
+
+func TestCheckCertAuth(t *testing.T) {
+	// Only scheme-less URIs without any "/" can pass validUsernameRegexp (it
+	// forbids ":" and "/"), so that's what a --cert-username-field=uri
+	// deployment must use.
+	uri := &url.URL{Opaque: "alice"}
+
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		URIs:           []*url.URL{uri},
+		EmailAddresses: []string{"alice@example.com"},
+	}
+
+	var tests = []struct {
+		name         string
+		field        CertUsernameField
+		req          *http.Request
+		wantUsername string
+		wantOK       bool
+	}{
+		{
+			name:         "no TLS connection state",
+			field:        CertUsernameCN,
+			req:          httptest.NewRequest(http.MethodGet, "/", nil),
+			wantUsername: "",
+			wantOK:       false,
+		},
+		{
+			name:  "unverified connection",
+			field: CertUsernameCN,
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.TLS = &tls.ConnectionState{}
+				return r
+			}(),
+			wantUsername: "",
+			wantOK:       false,
+		},
+		{
+			name:         "default field uses the common name",
+			field:        "",
+			req:          requestWithVerifiedCert(cert),
+			wantUsername: "alice",
+			wantOK:       true,
+		},
+		{
+			name:         "uri field uses the first SAN URI",
+			field:        CertUsernameURI,
+			req:          requestWithVerifiedCert(cert),
+			wantUsername: "alice",
+			wantOK:       true,
+		},
+		{
+			name:  "uri field with scheme and path is rejected as an unsafe username",
+			field: CertUsernameURI,
+			req: requestWithVerifiedCert(&x509.Certificate{
+				Subject: pkix.Name{CommonName: "bob"},
+				URIs:    []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/backup-client"}},
+			}),
+			wantUsername: "",
+			wantOK:       false,
+		},
+		{
+			name:         "email field uses the first SAN email",
+			field:        CertUsernameEmail,
+			req:          requestWithVerifiedCert(cert),
+			wantUsername: "alice@example.com",
+			wantOK:       true,
+		},
+		{
+			name:         "uri field with no SAN URI fails",
+			field:        CertUsernameURI,
+			req:          requestWithVerifiedCert(&x509.Certificate{Subject: pkix.Name{CommonName: "bob"}}),
+			wantUsername: "",
+			wantOK:       false,
+		},
+		{
+			name:         "unsafe common name is rejected",
+			field:        CertUsernameCN,
+			req:          requestWithVerifiedCert(&x509.Certificate{Subject: pkix.Name{CommonName: "../../etc/passwd"}}),
+			wantUsername: "",
+			wantOK:       false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Server{CertUsernameField: test.field}
+			username, ok := s.checkCertAuth(test.req)
+			if username != test.wantUsername || ok != test.wantOK {
+				t.Errorf("checkCertAuth() = (%q, %v), want (%q, %v)", username, ok, test.wantUsername, test.wantOK)
+			}
+		})
+	}
+}
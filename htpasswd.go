@@ -26,49 +26,65 @@ THE SOFTWARE.
 
 import (
 	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/subtle"
 	"encoding/base64"
 	"encoding/csv"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/restic/rest-server/metrics"
 )
 
-// CheckInterval represents how often we check for changes in htpasswd file.
+// CheckInterval represents how often we check for changes in htpasswd file,
+// used when no HtpasswdOptions are given and as the fallback when fsnotify
+// is unavailable.
 const CheckInterval = 30 * time.Second
 
-// PasswordCacheDuration represents how long authentication credentials are
-// cached in memory after they were successfully verified. This allows avoiding
-// repeatedly verifying the same authentication credentials.
-const PasswordCacheDuration = time.Minute
-
 // Lookup passwords in a htpasswd file.  The entries must have been created with -s for SHA encryption.
 
-type cacheEntry struct {
-	expiry   time.Time
-	verifier []byte
+// HtpasswdOptions configures an HtpasswdFile created with
+// NewHtpasswdFromFileWithOptions.
+type HtpasswdOptions struct {
+	// CheckInterval is how often the htpasswd file is polled for changes as
+	// a fallback when the fsnotify watcher cannot be set up. A negative
+	// value disables polling entirely, relying only on fsnotify and SIGHUP.
+	CheckInterval time.Duration
 }
 
 // HtpasswdFile is a map for usernames to passwords.
 type HtpasswdFile struct {
-	mutex    sync.Mutex
-	path     string
-	stat     os.FileInfo
-	throttle chan struct{}
-	users    map[string]string
-	cache    map[string]cacheEntry
+	mutex         sync.Mutex
+	path          string
+	stat          os.FileInfo
+	throttle      chan struct{}
+	checkInterval time.Duration
+	users         map[string]string
 }
 
 // NewHtpasswdFromFile reads the users and passwords from a htpasswd file and returns them.  If an error is encountered,
 // it is returned, together with a nil-Pointer for the HtpasswdFile.
 func NewHtpasswdFromFile(path string) (*HtpasswdFile, error) {
+	return NewHtpasswdFromFileWithOptions(path, HtpasswdOptions{CheckInterval: CheckInterval})
+}
+
+// NewHtpasswdFromFileWithOptions reads the users and passwords from a
+// htpasswd file and returns them, using opts to control how changes to the
+// file are detected. If an error is encountered, it is returned, together
+// with a nil-pointer for the HtpasswdFile.
+func NewHtpasswdFromFileWithOptions(path string, opts HtpasswdOptions) (*HtpasswdFile, error) {
+	// Clean once here so that watch()'s comparison against fsnotify event
+	// paths (which always arrive cleaned) works regardless of how path was
+	// spelled by the caller.
+	path = filepath.Clean(path)
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGHUP)
 	stat, err := os.Stat(path)
@@ -77,20 +93,23 @@ func NewHtpasswdFromFile(path string) (*HtpasswdFile, error) {
 	}
 
 	h := &HtpasswdFile{
-		mutex:    sync.Mutex{},
-		path:     path,
-		stat:     stat,
-		throttle: make(chan struct{}),
-		cache:    make(map[string]cacheEntry),
+		mutex:         sync.Mutex{},
+		path:          path,
+		stat:          stat,
+		throttle:      make(chan struct{}),
+		checkInterval: opts.CheckInterval,
 	}
 
 	if err := h.Reload(); err != nil {
 		return nil, err
 	}
 
-	// Start a goroutine that limits reload checks to once per CheckInterval
-	go h.throttleTimer()
-	go h.expiryTimer()
+	if err := h.watch(); err != nil {
+		log.Printf("Could not watch htpasswd file for changes, falling back to polling: %v", err)
+		if h.checkInterval >= 0 {
+			go h.throttleTimer()
+		}
+	}
 
 	go func() {
 		for range c {
@@ -106,32 +125,71 @@ func NewHtpasswdFromFile(path string) (*HtpasswdFile, error) {
 	return h, nil
 }
 
+// watch starts an fsnotify watcher on the htpasswd file's parent directory
+// (which reports changes to the file itself and also atomic rewrites via
+// rename, as done by `htpasswd -B`) and reloads the file immediately on
+// relevant events.
+// It returns an error if no watcher could be set up; the caller should fall
+// back to polling in that case.
+func (h *HtpasswdFile) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(filepath.Dir(h.path)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !htpasswdWatchEventRelevant(event, h.path) {
+					continue
+				}
+				if err := h.Reload(); err == nil {
+					log.Printf("Reloaded htpasswd file")
+				} else {
+					log.Printf("Could not reload htpasswd file: %v", err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("htpasswd watcher error: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// htpasswdWatchEventRelevant reports whether an fsnotify event observed while
+// watching path's parent directory should trigger a reload: the event must
+// name path itself (after cleaning, since fsnotify always reports cleaned
+// paths) and carry an operation that can change its contents.
+func htpasswdWatchEventRelevant(event fsnotify.Event, path string) bool {
+	if filepath.Clean(event.Name) != path {
+		return false
+	}
+	return event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0
+}
+
 // throttleTimer sends at most one message per CheckInterval to throttle file change checks.
 func (h *HtpasswdFile) throttleTimer() {
 	var check struct{}
 	for {
-		time.Sleep(CheckInterval)
+		time.Sleep(h.checkInterval)
 		h.throttle <- check
 	}
 }
 
-func (h *HtpasswdFile) expiryTimer() {
-	for {
-		time.Sleep(5 * time.Second)
-		now := time.Now()
-		h.mutex.Lock()
-		var zeros [sha256.Size]byte
-		// try to wipe expired cache entries
-		for user, entry := range h.cache {
-			if entry.expiry.After(now) {
-				copy(entry.verifier, zeros[:])
-				delete(h.cache, user)
-			}
-		}
-		h.mutex.Unlock()
-	}
-}
-
 var validUsernameRegexp = regexp.MustCompile(`^[\p{L}\d@.-]+$`)
 
 // Reload reloads the htpasswd file. If the reload fails, the Users map is not changed and the error is returned.
@@ -162,13 +220,6 @@ func (h *HtpasswdFile) Reload() error {
 
 	// Replace the Users map
 	h.mutex.Lock()
-	var zeros [sha256.Size]byte
-	// try to wipe the old cache entries
-	for _, entry := range h.cache {
-		copy(entry.verifier, zeros[:])
-	}
-	h.cache = make(map[string]cacheEntry)
-
 	h.users = users
 	h.mutex.Unlock()
 
@@ -211,71 +262,53 @@ func (h *HtpasswdFile) ReloadCheck() error {
 	return nil
 }
 
+var (
+	shaRe = regexp.MustCompile(`^{SHA}`)
+	bcrRe = regexp.MustCompile(`^\$2b\$|^\$2a\$|^\$2y\$`)
+)
+
 // Validate returns true if password matches the stored password for user.  If no password for user is stored, or the
-// password is wrong, false is returned.
-func (h *HtpasswdFile) Validate(user string, password string) bool {
-	_ = h.ReloadCheck()
+// password is wrong, false is returned. It implements the Authenticator interface.
+func (h *HtpasswdFile) Validate(user string, password string) (bool, error) {
+	start := time.Now()
+	result := metrics.ResultBadPassword
+	defer func() {
+		metrics.AuthAttemptsTotal.WithLabelValues(result).Inc()
+		metrics.AuthDurationSeconds.WithLabelValues("bcrypt").Observe(time.Since(start).Seconds())
+	}()
 
-	hash := sha256.New()
-	// hash.Write can never fail
-	_, _ = hash.Write([]byte(user))
-	_, _ = hash.Write([]byte(":"))
-	_, _ = hash.Write([]byte(password))
+	_ = h.ReloadCheck()
 
 	h.mutex.Lock()
-	// avoid race conditions with cache replacements
-	cache := h.cache
 	realPassword, exists := h.users[user]
-	entry, cacheExists := h.cache[user]
 	h.mutex.Unlock()
 
 	if !exists {
-		return false
+		result = metrics.ResultBadUser
+		return false, nil
 	}
 
-	if cacheExists && subtle.ConstantTimeCompare(entry.verifier, hash.Sum(nil)) == 1 {
-		h.mutex.Lock()
-		// repurpose mutex to prevent concurrent cache updates
-		// extend cache entry
-		cache[user] = cacheEntry{
-			verifier: entry.verifier,
-			expiry:   time.Now().Add(PasswordCacheDuration),
-		}
-		h.mutex.Unlock()
-		return true
-	}
-
-	var shaRe = regexp.MustCompile(`^{SHA}`)
-	var bcrRe = regexp.MustCompile(`^\$2b\$|^\$2a\$|^\$2y\$`)
-
-	isValid := false
-
 	switch {
 	case shaRe.MatchString(realPassword):
 		d := sha1.New()
 		_, _ = d.Write([]byte(password))
 		if realPassword[5:] == base64.StdEncoding.EncodeToString(d.Sum(nil)) {
-			isValid = true
+			result = metrics.ResultOK
+			return true, nil
 		}
 	case bcrRe.MatchString(realPassword):
-		err := bcrypt.CompareHashAndPassword([]byte(realPassword), []byte(password))
-		if err == nil {
-			isValid = true
+		if err := bcrypt.CompareHashAndPassword([]byte(realPassword), []byte(password)); err == nil {
+			result = metrics.ResultOK
+			return true, nil
 		}
 	}
 
-	if !isValid {
-		log.Printf("Invalid htpasswd entry for %s.", user)
-		return false
-	}
-
-	h.mutex.Lock()
-	// repurpose mutex to prevent concurrent cache updates
-	cache[user] = cacheEntry{
-		verifier: hash.Sum(nil),
-		expiry:   time.Now().Add(PasswordCacheDuration),
-	}
-	h.mutex.Unlock()
+	log.Printf("Invalid htpasswd entry for %s.", user)
+	return false, nil
+}
 
-	return true
+// Name returns a short identifier for this Authenticator, used in log
+// messages and metrics.
+func (h *HtpasswdFile) Name() string {
+	return "htpasswd"
 }
@@ -0,0 +1,67 @@
+package restserver
+
+import (
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestHtpasswdWatchEventRelevant(t *testing.T) {
+	var tests = []struct {
+		name  string
+		event fsnotify.Event
+		path  string
+		want  bool
+	}{
+		{
+			name:  "write to the watched file reloads",
+			event: fsnotify.Event{Name: "/etc/restic/.htpasswd", Op: fsnotify.Write},
+			path:  "/etc/restic/.htpasswd",
+			want:  true,
+		},
+		{
+			name:  "create reloads, e.g. after htpasswd -B's rename",
+			event: fsnotify.Event{Name: "/etc/restic/.htpasswd", Op: fsnotify.Create},
+			path:  "/etc/restic/.htpasswd",
+			want:  true,
+		},
+		{
+			name:  "rename reloads",
+			event: fsnotify.Event{Name: "/etc/restic/.htpasswd", Op: fsnotify.Rename},
+			path:  "/etc/restic/.htpasswd",
+			want:  true,
+		},
+		{
+			name:  "uncleaned event name still matches a cleaned path",
+			event: fsnotify.Event{Name: "/etc/restic/../restic/.htpasswd", Op: fsnotify.Write},
+			path:  "/etc/restic/.htpasswd",
+			want:  true,
+		},
+		{
+			name:  "unrelated file in the watched directory is ignored",
+			event: fsnotify.Event{Name: "/etc/restic/.htpasswd.swp", Op: fsnotify.Write},
+			path:  "/etc/restic/.htpasswd",
+			want:  false,
+		},
+		{
+			name:  "chmod on the watched file is ignored",
+			event: fsnotify.Event{Name: "/etc/restic/.htpasswd", Op: fsnotify.Chmod},
+			path:  "/etc/restic/.htpasswd",
+			want:  false,
+		},
+		{
+			name:  "remove of the watched file is ignored",
+			event: fsnotify.Event{Name: "/etc/restic/.htpasswd", Op: fsnotify.Remove},
+			path:  "/etc/restic/.htpasswd",
+			want:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := htpasswdWatchEventRelevant(test.event, test.path); got != test.want {
+				t.Errorf("htpasswdWatchEventRelevant(%+v, %q) = %v, want %v", test.event, test.path, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Package metrics defines and registers the Prometheus metrics exported by
+// rest-server, which its authentication and request-handling code paths
+// update.
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Authentication results recorded in AuthAttemptsTotal.
+const (
+	ResultOK          = "ok"
+	ResultBadUser     = "bad_user"
+	ResultBadPassword = "bad_password"
+	ResultCacheHit    = "cache_hit"
+)
+
+// File types recorded in RepoFiles, mirroring a restic repository's on-disk
+// layout.
+const (
+	FileTypeData     = "data"
+	FileTypeIndex    = "index"
+	FileTypeSnapshot = "snapshot"
+	FileTypeKey      = "key"
+	FileTypeLock     = "lock"
+)
+
+var (
+	// AuthAttemptsTotal counts authentication attempts by outcome.
+	AuthAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rest_server_auth_attempts_total",
+		Help: "Total number of authentication attempts by result.",
+	}, []string{"result"})
+
+	// AuthDurationSeconds measures how long authentication took, bucketed
+	// separately for cache hits and for requests that went all the way to
+	// the backend (bcrypt, LDAP bind, exec helper, ...) so that operators
+	// can see the cost of the slow path.
+	AuthDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rest_server_auth_duration_seconds",
+		Help:    "Time spent validating credentials.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"cache"})
+
+	// RequestsTotal counts handled HTTP requests.
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rest_server_requests_total",
+		Help: "Total number of HTTP requests handled.",
+	}, []string{"method", "repo", "user", "code"})
+
+	// RequestBytes observes the size of request bodies.
+	RequestBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rest_server_request_bytes",
+		Help:    "Size of HTTP request bodies.",
+		Buckets: prometheus.ExponentialBuckets(64, 8, 8),
+	})
+
+	// ResponseBytes observes the size of response bodies.
+	ResponseBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rest_server_response_bytes",
+		Help:    "Size of HTTP response bodies.",
+		Buckets: prometheus.ExponentialBuckets(64, 8, 8),
+	})
+
+	// RepoSizeBytes reports the on-disk size of each repository.
+	RepoSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rest_server_repo_size_bytes",
+		Help: "Size of a repository on disk, in bytes.",
+	}, []string{"repo", "user"})
+
+	// RepoFiles reports the number of files of each type in a repository.
+	RepoFiles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rest_server_repo_files",
+		Help: "Number of files in a repository, by type.",
+	}, []string{"repo", "user", "type"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		AuthAttemptsTotal,
+		AuthDurationSeconds,
+		RequestsTotal,
+		RequestBytes,
+		ResponseBytes,
+		RepoSizeBytes,
+		RepoFiles,
+	)
+}
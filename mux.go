@@ -1,14 +1,19 @@
 package restserver
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/handlers"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/restic/rest-server/metrics"
 	"github.com/restic/rest-server/quota"
 )
 
@@ -29,33 +34,220 @@ func (s *Server) logHandler(next http.Handler) http.Handler {
 	return handlers.CombinedLoggingHandler(accessLog, next)
 }
 
+// requestMetricsKey is the context key under which metricsMiddleware stashes
+// a *requestMetrics for checkAuth to fill in, once it knows who the caller
+// actually is.
+type requestMetricsKey struct{}
+
+// requestMetrics carries label values that can only be derived after
+// authentication has run, for metricsMiddleware to read back once the
+// request completes.
+type requestMetrics struct {
+	user string
+}
+
 func (s *Server) checkAuth(r *http.Request) (username string, ok bool) {
+	defer func() {
+		if !ok {
+			return
+		}
+		if rm, found := r.Context().Value(requestMetricsKey{}).(*requestMetrics); found {
+			rm.user = username
+		}
+	}()
+
 	if s.NoAuth {
 		return username, true
 	}
+	if s.ClientCertAuth {
+		return s.checkCertAuth(r)
+	}
 	var password string
 	username, password, ok = r.BasicAuth()
-	if !ok || !s.htpasswdFile.Validate(username, password) {
+	if !ok {
+		return "", false
+	}
+	valid, err := s.authenticator.Validate(username, password)
+	if err != nil {
+		log.Printf("%s: authentication error for user %s: %v", s.authenticator.Name(), username, err)
+		return "", false
+	}
+	if !valid {
 		return "", false
 	}
 	return username, true
 }
 
-// NewHandler returns the master HTTP multiplexer/router.
-func NewHandler(server *Server) (http.Handler, error) {
-	if !server.NoAuth {
-		var err error
-		server.htpasswdFile, err = NewHtpasswdFromFile(filepath.Join(server.Path, ".htpasswd"))
+// metricsAuthHandler wraps next with HTTP Basic authentication against auth,
+// for use in front of the /metrics endpoint.
+func metricsAuthHandler(auth Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if ok {
+			valid, err := auth.Validate(username, password)
+			if err != nil {
+				log.Printf("%s: authentication error for user %s: %v", auth.Name(), username, err)
+				ok = false
+			}
+			ok = ok && valid
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="restic-server metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newMetricsHandler returns the handler serving /metrics, guarded by HTTP
+// Basic authentication unless MetricsNoAuth is set. By default it shares
+// credentials with the main .htpasswd file; --metrics-htpasswd points it at
+// a separate one instead.
+func (s *Server) newMetricsHandler() (http.Handler, error) {
+	if s.MetricsNoAuth {
+		return promhttp.Handler(), nil
+	}
+
+	path := s.MetricsHtpasswd
+	if path == "" {
+		path = filepath.Join(s.Path, ".htpasswd")
+	}
+
+	auth, err := NewHtpasswdFromFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load htpasswd for /metrics (use --metrics-no-auth to disable): %v", err)
+	}
+
+	return metricsAuthHandler(auth, promhttp.Handler()), nil
+}
+
+// metricsResponseWriter wraps an http.ResponseWriter to capture the status
+// code and number of bytes written, for rest_server_requests_total and
+// rest_server_response_bytes.
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (w *metricsResponseWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *metricsResponseWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// repoFromPath returns the repository name used in the URL path: in
+// PrivateRepos mode, where repositories live one level below a per-user
+// directory, that's the first two segments joined by "/" (matching the
+// on-disk layout refreshRepoMetrics walks); otherwise it's just the first
+// segment, or "" for requests at the repository root (single-repo mode). The
+// result is client-controlled and must be checked against a bounded, known
+// set (s.knownRepos) before it's used as a metrics label.
+func repoFromPath(path string, privateRepos bool) string {
+	path = strings.TrimPrefix(path, "/")
+	parts := strings.SplitN(path, "/", 3)
+
+	if privateRepos {
+		if len(parts) < 2 || parts[1] == "" {
+			return ""
+		}
+		return parts[0] + "/" + parts[1]
+	}
+	return parts[0]
+}
+
+// metricsMiddleware wraps next to populate rest_server_requests_total,
+// rest_server_request_bytes and rest_server_response_bytes. The repo label
+// is only set for segments s.knownRepos recognizes (refreshed periodically
+// by refreshRepoMetrics) so that an unauthenticated client can't blow up
+// its cardinality; the user label comes from checkAuth's result, never
+// straight from the request, so it's only ever set for requests that
+// actually authenticated (Basic or client-cert).
+func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo := repoFromPath(r.URL.Path, s.PrivateRepos)
+		if !s.knownRepos.Has(repo) {
+			repo = ""
+		}
+
+		rm := &requestMetrics{}
+		r = r.WithContext(context.WithValue(r.Context(), requestMetricsKey{}, rm))
+
+		metrics.RequestBytes.Observe(float64(r.ContentLength))
+
+		mw := &metricsResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(mw, r)
+
+		metrics.ResponseBytes.Observe(float64(mw.bytes))
+		metrics.RequestsTotal.WithLabelValues(r.Method, repo, rm.user, strconv.Itoa(mw.code)).Inc()
+	})
+}
+
+// tlsConfig builds the *tls.Config the caller should pass to
+// http.Server.ListenAndServeTLS, wiring together --acme and
+// --auth-cert/--client-ca. It returns nil if neither is enabled, leaving
+// certificate handling to --tls-cert/--tls-key.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	var cfg *tls.Config
+
+	if s.ACME {
+		manager := newAutocertManager(s.ACMEHosts, s.ACMECacheDir, s.ACMEStaging)
+		cfg = manager.TLSConfig()
+
+		go func() {
+			if err := http.ListenAndServe(":80", acmeChallengeHandler(manager)); err != nil {
+				log.Fatalf("ACME challenge listener failed: %v", err)
+			}
+		}()
+	}
+
+	if s.ClientCertAuth {
+		clientCertConfig, err := newClientCertTLSConfig(s.ClientCA)
 		if err != nil {
-			return nil, fmt.Errorf("cannot load .htpasswd (use --no-auth to disable): %v", err)
+			return nil, fmt.Errorf("cannot set up client certificate authentication: %v", err)
 		}
+		if cfg == nil {
+			cfg = clientCertConfig
+		} else {
+			cfg.ClientAuth = clientCertConfig.ClientAuth
+			cfg.ClientCAs = clientCertConfig.ClientCAs
+		}
+	}
+
+	return cfg, nil
+}
+
+// NewHandler returns the master HTTP multiplexer/router, along with the
+// *tls.Config (nil if --acme and --auth-cert are both unused) the caller
+// should use to serve it over HTTPS.
+func NewHandler(server *Server) (http.Handler, *tls.Config, error) {
+	if !server.NoAuth && !server.ClientCertAuth {
+		authenticator, err := NewAuthenticatorFromSpec(server.Auth, filepath.Join(server.Path, ".htpasswd"), server.HtpasswdReload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot set up authentication (use --no-auth to disable): %v", err)
+		}
+		server.authenticator = newCachingAuthenticator(authenticator, PasswordCacheDuration)
+	}
+
+	tlsConfig, err := server.tlsConfig()
+	if err != nil {
+		return nil, nil, err
 	}
 
 	if server.MaxRepoSize > 0 {
 		log.Printf("Initializing quota (can take a while)...")
 		qm, err := quota.New(server.Path, server.MaxRepoSize)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		server.quotaManager = qm
 		log.Printf("Quota initialized, currenly using %.2f GiB", float64(qm.SpaceUsed()/1024/1024))
@@ -63,10 +255,26 @@ func NewHandler(server *Server) (http.Handler, error) {
 
 	mux := http.NewServeMux()
 	if server.Prometheus {
-		// FIXME: need auth like in previous version?
-		mux.Handle("/metrics", promhttp.Handler())
+		metricsHandler, err := server.newMetricsHandler()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if server.MetricsListen != "" {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle("/metrics", metricsHandler)
+			go func() {
+				if err := http.ListenAndServe(server.MetricsListen, metricsMux); err != nil {
+					log.Fatalf("metrics listener failed: %v", err)
+				}
+			}()
+		} else {
+			mux.Handle("/metrics", metricsHandler)
+		}
+
+		go server.refreshRepoMetricsLoop()
 	}
-	mux.Handle("/", server)
+	mux.Handle("/", server.metricsMiddleware(server))
 
 	var handler http.Handler = mux
 	if server.Debug {
@@ -75,5 +283,5 @@ func NewHandler(server *Server) (http.Handler, error) {
 	if server.Log != "" {
 		handler = server.logHandler(handler)
 	}
-	return handler, nil
+	return handler, tlsConfig, nil
 }
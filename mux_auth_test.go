@@ -0,0 +1,72 @@
+package restserver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsAuthHandler(t *testing.T) {
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var tests = []struct {
+		name       string
+		auth       Authenticator
+		setBasic   bool
+		user, pass string
+		wantCode   int
+	}{
+		{
+			name:     "valid credentials reach the wrapped handler",
+			auth:     &fakeAuthenticator{valid: true},
+			setBasic: true,
+			user:     "alice",
+			pass:     "s3cret",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "invalid credentials are rejected",
+			auth:     &fakeAuthenticator{valid: false},
+			setBasic: true,
+			user:     "alice",
+			pass:     "wrong",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "missing credentials are rejected",
+			auth:     &fakeAuthenticator{valid: true},
+			setBasic: false,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "backend error is treated as a rejection",
+			auth:     &fakeAuthenticator{valid: true, err: errors.New("backend unreachable")},
+			setBasic: true,
+			user:     "alice",
+			pass:     "s3cret",
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if test.setBasic {
+				req.SetBasicAuth(test.user, test.pass)
+			}
+
+			w := httptest.NewRecorder()
+			metricsAuthHandler(test.auth, okHandler).ServeHTTP(w, req)
+
+			if w.Code != test.wantCode {
+				t.Errorf("got status %d, want %d", w.Code, test.wantCode)
+			}
+			if test.wantCode == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected WWW-Authenticate header on a rejected request")
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package restserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRepoFromPath(t *testing.T) {
+	var tests = []struct {
+		path         string
+		privateRepos bool
+		result       string
+	}{
+		{"/", false, ""},
+		{"", false, ""},
+		{"/myrepo", false, "myrepo"},
+		{"/myrepo/", false, "myrepo"},
+		{"/myrepo/config", false, "myrepo"},
+		{"/myrepo/data/abcd", false, "myrepo"},
+		{"/", true, ""},
+		{"/alice", true, ""},
+		{"/alice/", true, ""},
+		{"/alice/myrepo", true, "alice/myrepo"},
+		{"/alice/myrepo/config", true, "alice/myrepo"},
+		{"/alice/myrepo/data/abcd", true, "alice/myrepo"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			if got := repoFromPath(test.path, test.privateRepos); got != test.result {
+				t.Errorf("repoFromPath(%q, %v) = %q, want %q", test.path, test.privateRepos, got, test.result)
+			}
+		})
+	}
+}
+
+func TestRepoSet(t *testing.T) {
+	var s repoSet
+
+	if s.Has("myrepo") {
+		t.Fatal("empty repoSet must not contain anything")
+	}
+
+	s.Set([]string{"myrepo", "otherrepo"})
+	if !s.Has("myrepo") || !s.Has("otherrepo") {
+		t.Fatal("repoSet should contain the names it was Set with")
+	}
+	if s.Has("unknown") {
+		t.Fatal("repoSet should not contain names it wasn't Set with")
+	}
+
+	// Set replaces the previous contents rather than merging into them.
+	s.Set([]string{"otherrepo"})
+	if s.Has("myrepo") {
+		t.Fatal("repoSet.Set should replace, not merge, the known names")
+	}
+}
+
+// TestCheckAuthPopulatesRequestMetricsUser verifies that checkAuth records
+// the authenticated username (and only the authenticated username) on the
+// requestMetrics stashed in the request context by metricsMiddleware, since
+// that's the one place both Basic and client-cert auth funnel through.
+func TestCheckAuthPopulatesRequestMetricsUser(t *testing.T) {
+	newRequestWithMetrics := func() (*http.Request, *requestMetrics) {
+		rm := &requestMetrics{}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), requestMetricsKey{}, rm))
+		return req, rm
+	}
+
+	t.Run("no-auth leaves user empty", func(t *testing.T) {
+		s := &Server{NoAuth: true}
+		req, rm := newRequestWithMetrics()
+
+		if _, ok := s.checkAuth(req); !ok {
+			t.Fatal("expected checkAuth to succeed in NoAuth mode")
+		}
+		if rm.user != "" {
+			t.Errorf("expected empty user, got %q", rm.user)
+		}
+	})
+
+	t.Run("successful basic auth records the username", func(t *testing.T) {
+		s := &Server{authenticator: newCachingAuthenticator(&fakeAuthenticator{valid: true}, PasswordCacheDuration)}
+		req, rm := newRequestWithMetrics()
+		req.SetBasicAuth("alice", "s3cret")
+
+		username, ok := s.checkAuth(req)
+		if !ok {
+			t.Fatal("expected checkAuth to succeed")
+		}
+		if username != "alice" || rm.user != "alice" {
+			t.Errorf("expected user %q to be recorded, got username=%q rm.user=%q", "alice", username, rm.user)
+		}
+	})
+
+	t.Run("failed basic auth leaves user empty", func(t *testing.T) {
+		s := &Server{authenticator: newCachingAuthenticator(&fakeAuthenticator{valid: false}, PasswordCacheDuration)}
+		req, rm := newRequestWithMetrics()
+		req.SetBasicAuth("mallory", "wrong")
+
+		if _, ok := s.checkAuth(req); ok {
+			t.Fatal("expected checkAuth to fail")
+		}
+		if rm.user != "" {
+			t.Errorf("expected no user to be recorded for a failed auth attempt, got %q", rm.user)
+		}
+	})
+}
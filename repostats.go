@@ -0,0 +1,145 @@
+package restserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restic/rest-server/metrics"
+)
+
+// repoSet is a concurrency-safe set of known repository names as
+// repoFromPath extracts them: the first URL path segment, or in PrivateRepos
+// mode the first two segments joined by "/". It exists to bound the
+// cardinality of metrics labels derived from request paths, which are
+// otherwise attacker-controlled and unbounded.
+type repoSet struct {
+	mutex sync.RWMutex
+	names map[string]struct{}
+}
+
+// Has reports whether name is a known repository segment.
+func (s *repoSet) Has(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.names[name]
+	return ok
+}
+
+// Set replaces the known set of repository segments with names.
+func (s *repoSet) Set(names []string) {
+	m := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		m[n] = struct{}{}
+	}
+	s.mutex.Lock()
+	s.names = m
+	s.mutex.Unlock()
+}
+
+// repoMetricsInterval is how often rest_server_repo_size_bytes and
+// rest_server_repo_files are refreshed. Walking the whole repository tree
+// isn't cheap, so this intentionally runs on a slow ticker.
+const repoMetricsInterval = 5 * time.Minute
+
+// refreshRepoMetricsLoop periodically walks s.Path and updates the
+// per-repository size and file-count gauges. It never returns and is meant
+// to be started in its own goroutine.
+func (s *Server) refreshRepoMetricsLoop() {
+	for {
+		s.refreshRepoMetrics()
+		time.Sleep(repoMetricsInterval)
+	}
+}
+
+// refreshRepoMetrics walks s.Path looking for restic repositories (any
+// directory containing a "config" file) and updates RepoSizeBytes and
+// RepoFiles for each one found. In PrivateRepos mode, repositories live one
+// level below a per-user directory; the user label is empty otherwise.
+func (s *Server) refreshRepoMetrics() {
+	var segments []string
+
+	_ = filepath.Walk(s.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(path, "config")); err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.Path, path)
+		if err != nil {
+			return nil
+		}
+
+		var repo, user string
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if s.PrivateRepos && len(parts) >= 2 {
+			user, repo = parts[0], strings.Join(parts[1:], "/")
+			// Bound on the same two segments repoFromPath extracts from the
+			// URL path, not the full (possibly nested) repo name.
+			segments = append(segments, parts[0]+"/"+parts[1])
+		} else {
+			user, repo = "", rel
+			segment := parts[0]
+			if repo == "." {
+				// rel is "." for the repository root itself (single-repo
+				// mode); match repoFromPath's convention of "" there rather
+				// than leaking the filepath.Rel result into the label.
+				repo, segment = "", ""
+			}
+			segments = append(segments, segment)
+		}
+
+		size, files := repoStats(path)
+		metrics.RepoSizeBytes.WithLabelValues(repo, user).Set(float64(size))
+		for fileType, count := range files {
+			metrics.RepoFiles.WithLabelValues(repo, user, fileType).Set(float64(count))
+		}
+
+		return filepath.SkipDir
+	})
+
+	s.knownRepos.Set(segments)
+}
+
+// repoStats returns the total size in bytes and per-type file counts of the
+// restic repository rooted at path.
+func repoStats(path string) (size int64, files map[string]int) {
+	files = map[string]int{
+		metrics.FileTypeData:     0,
+		metrics.FileTypeIndex:    0,
+		metrics.FileTypeSnapshot: 0,
+		metrics.FileTypeKey:      0,
+		metrics.FileTypeLock:     0,
+	}
+
+	_ = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		size += info.Size()
+
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return nil
+		}
+		switch strings.Split(filepath.ToSlash(rel), "/")[0] {
+		case "data":
+			files[metrics.FileTypeData]++
+		case "index":
+			files[metrics.FileTypeIndex]++
+		case "snapshots":
+			files[metrics.FileTypeSnapshot]++
+		case "keys":
+			files[metrics.FileTypeKey]++
+		case "locks":
+			files[metrics.FileTypeLock]++
+		}
+		return nil
+	})
+
+	return size, files
+}